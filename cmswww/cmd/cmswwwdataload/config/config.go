@@ -12,15 +12,33 @@ import (
 	"strings"
 
 	flags "github.com/btcsuite/go-flags"
+	"github.com/decred/dcrd/chaincfg"
+	"github.com/decred/slog"
+
+	"github.com/decred/contractor-mgmt/cmswww/database"
+	"github.com/decred/contractor-mgmt/cmswww/hdwallet"
+	"github.com/decred/contractor-mgmt/cmswww/logging"
 	"github.com/decred/contractor-mgmt/cmswww/sharedconfig"
+	"github.com/decred/contractor-mgmt/cmswww/totp"
 )
 
+// log is the subsystem logger for the dataloader, wired up once Load has
+// worked out where CmswwwLogFile lives. It is a no-op until then.
+var log = slog.Disabled
+
 const (
-	defaultDataDirname          = "dataload"
-	defaultConfigFilename       = "cmswwwdataload.conf"
-	defaultPoliteiadLogFilename = "politeiad.log"
-	defaultCmswwwLogFilename    = "cmswww.log"
-	defaultLogLevel             = "info"
+	defaultDataDirname                 = "dataload"
+	defaultConfigFilename              = "cmswwwdataload.conf"
+	defaultPoliteiadLogFilename        = "politeiad.log"
+	defaultCmswwwLogFilename           = "cmswww.log"
+	defaultLogLevel                    = "info"
+	defaultContractorExtendedPublicKey = "faketpub"
+
+	// defaultPassword is the out-of-the-box placeholder for both
+	// AdminPass and ContractorPass. It is intentionally weak and is
+	// exempted from validatePassword so a fresh install still runs; any
+	// operator-supplied value is held to the real bar.
+	defaultPassword = "password"
 )
 
 var (
@@ -32,21 +50,22 @@ var (
 //
 // See loadConfig for details on the configuration load process.
 type Config struct {
-	AdminEmail                  string `long:"adminemail" description:"Admin user email address"`
-	AdminUser                   string `long:"adminuser" description:"Admin username"`
-	AdminPass                   string `long:"adminpass" description:"Admin password"`
-	ContractorEmail             string `long:"contractoremail" description:"Contractor user email address"`
-	ContractorUser              string `long:"contractoruser" description:"Contractor user username"`
-	ContractorPass              string `long:"contractorpass" description:"Contractor user password"`
-	ContractorName              string `long:"contractorname" description:"Contractor user full name"`
-	ContractorLocation          string `long:"contractorlocation" description:"Contractor user physical location"`
-	ContractorExtendedPublicKey string `long:"contractorextendedpublickey" description:"Contractor extended public key"`
-	Verbose                     bool   `short:"v" long:"verbose" description:"Verbose output"`
-	DataDir                     string `long:"datadir" description:"Path to config/data directory"`
-	ConfigFile                  string `long:"configfile" description:"Path to configuration file"`
-	DebugLevel                  string `long:"debuglevel" description:"Logging level to use for servers {trace, debug, info, warn, error, critical}"`
-	DeleteData                  bool   `long:"deletedata" description:"Delete all existing data from politeiad and cmswww before loading data"`
-	IncludeTests                bool   `long:"includetests" description:"Includes running tests of different commands."`
+	AdminEmail                  string       `long:"adminemail" description:"Admin user email address"`
+	AdminUser                   string       `long:"adminuser" description:"Admin username"`
+	AdminPass                   SecretString `long:"adminpass" description:"Admin password"`
+	ContractorEmail             string       `long:"contractoremail" description:"Contractor user email address"`
+	ContractorUser              string       `long:"contractoruser" description:"Contractor user username"`
+	ContractorPass              SecretString `long:"contractorpass" description:"Contractor user password"`
+	ContractorName              string       `long:"contractorname" description:"Contractor user full name"`
+	ContractorLocation          string       `long:"contractorlocation" description:"Contractor user physical location"`
+	ContractorExtendedPublicKey string       `long:"contractorextendedpublickey" description:"Contractor extended public key"`
+	Verbose                     bool         `short:"v" long:"verbose" description:"Verbose output"`
+	DataDir                     string       `long:"datadir" description:"Path to config/data directory"`
+	ConfigFile                  string       `long:"configfile" description:"Path to configuration file"`
+	DebugLevel                  string       `long:"debuglevel" description:"Logging level to use for servers {trace, debug, info, warn, error, critical}"`
+	DeleteData                  bool         `long:"deletedata" description:"Delete all existing data from politeiad and cmswww before loading data"`
+	IncludeTests                bool         `long:"includetests" description:"Includes running tests of different commands."`
+	AdminTOTP                   bool         `long:"admintotp" description:"Provision the admin user with TOTP two-factor login"`
 	PoliteiadLogFile            string
 	CmswwwLogFile               string
 }
@@ -84,10 +103,11 @@ func newConfigParser(cfg *Config, options flags.Options) *flags.Parser {
 // line options.
 //
 // The configuration proceeds as follows:
-// 	1) Start with a default config with sane settings
-// 	2) Pre-parse the command line to check for an alternative config file
-// 	3) Load configuration file overwriting defaults with any specified options
-// 	4) Parse CLI options and overwrite/add any specified options
+//  1. Start with a default config with sane settings
+//  2. Pre-parse the command line to check for an alternative config file
+//  3. Load configuration file overwriting defaults with any specified options
+//  4. Parse CLI options and overwrite/add any specified options
+//  5. Apply CMSWWW_* environment variables, overriding all of the above
 //
 // The above results in rpc functioning properly without any config settings
 // while still allowing the user to override settings with config files and
@@ -97,13 +117,13 @@ func Load() (*Config, error) {
 	cfg := Config{
 		AdminEmail:                  "admin@example.com",
 		AdminUser:                   "admin",
-		AdminPass:                   "password",
+		AdminPass:                   defaultPassword,
 		ContractorEmail:             "contractor@example.com",
 		ContractorUser:              "contractor",
-		ContractorPass:              "password",
+		ContractorPass:              defaultPassword,
 		ContractorName:              "John Smith",
 		ContractorLocation:          "Dallas, TX, USA",
-		ContractorExtendedPublicKey: "faketpub",
+		ContractorExtendedPublicKey: defaultContractorExtendedPublicKey,
 		DeleteData:                  false,
 		Verbose:                     false,
 		DataDir:                     defaultDataDir,
@@ -165,6 +185,11 @@ func Load() (*Config, error) {
 		return nil, err
 	}
 
+	// Environment variables always take precedence over the config file
+	// and command-line flags, so secrets never need to sit in
+	// cmswwwdataload.conf.
+	applyEnvOverrides(&cfg)
+
 	// Create the data directory if it doesn't already exist.
 	funcName := "loadConfig"
 	err = os.MkdirAll(cfg.DataDir, 0700)
@@ -185,14 +210,95 @@ func Load() (*Config, error) {
 		return nil, err
 	}
 
-	if configFileError != nil {
-		fmt.Printf("WARNING: %v\n", configFileError)
-	}
-
 	cfg.PoliteiadLogFile = filepath.Join(cfg.DataDir,
 		defaultPoliteiadLogFilename)
 	cfg.CmswwwLogFile = filepath.Join(cfg.DataDir,
 		defaultCmswwwLogFilename)
 
+	logBackend, err := logging.New(cfg.CmswwwLogFile, cfg.DebugLevel, cfg.Verbose)
+	if err != nil {
+		err := fmt.Errorf("%s: init logging: %v", funcName, err)
+		fmt.Fprintln(os.Stderr, err)
+		return nil, err
+	}
+	log = logBackend.Logger("CMSW")
+	database.UseLogger(logBackend.Logger("CMSW"))
+
+	if configFileError != nil {
+		log.Warnf("%v", configFileError)
+	}
+
+	if err := validateEmail("adminemail", cfg.AdminEmail); err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	if err := validateEmail("contractoremail", cfg.ContractorEmail); err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	if string(cfg.AdminPass) == defaultPassword {
+		log.Warnf("adminpass is still the default placeholder; set a real " +
+			"password before running against production data")
+	} else if err := validatePassword("adminpass", cfg.AdminPass); err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	if string(cfg.ContractorPass) == defaultPassword {
+		log.Warnf("contractorpass is still the default placeholder; set a " +
+			"real password before running against production data")
+	} else if err := validatePassword("contractorpass", cfg.ContractorPass); err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	// Validate the contractor's extended public key and, when requested,
+	// pre-generate the first payout address so smoke tests have something
+	// to exercise without waiting on a live invoice.
+	if cfg.ContractorExtendedPublicKey != defaultContractorExtendedPublicKey {
+		w, err := hdwallet.New(cfg.ContractorExtendedPublicKey,
+			&chaincfg.MainNetParams)
+		if err != nil {
+			err := newConfigError("contractorextendedpublickey", err)
+			log.Error(err)
+			return nil, err
+		}
+
+		if cfg.IncludeTests {
+			addr, err := w.AddressAt(0)
+			if err != nil {
+				err := newConfigError("contractorextendedpublickey",
+					fmt.Errorf("failed to derive test payout address: %v", err))
+				log.Error(err)
+				return nil, err
+			}
+			log.Infof("Contractor first payout address: %s", addr)
+		}
+	}
+
+	// Generate an admin TOTP secret and backup codes and print the
+	// otpauth:// URI and codes to stdout once so they can be scanned/saved.
+	// Neither the secret nor the backup code hashes are written to disk,
+	// returned in Config, or passed to database.Database.EnableTOTP; they
+	// are discarded when Load returns.
+	if cfg.AdminTOTP {
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			err := newConfigError("admintotp", err)
+			log.Error(err)
+			return nil, err
+		}
+		uri := totp.ProvisioningURI("cmswww", cfg.AdminEmail, secret)
+		fmt.Printf("Admin TOTP provisioning URI (scan this now, it will not be shown again): %s\n", uri)
+
+		codes, _, err := totp.GenerateBackupCodes()
+		if err != nil {
+			err := newConfigError("admintotp", err)
+			log.Error(err)
+			return nil, err
+		}
+		fmt.Printf("Admin TOTP backup codes (save these now, they will not be shown again): %s\n",
+			strings.Join(codes, " "))
+	}
+
 	return &cfg, nil
 }
@@ -0,0 +1,32 @@
+// Copyright (c) 2013-2014 The btcsuite developers
+// Copyright (c) 2015-2018 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package config
+
+import "os"
+
+// applyEnvOverrides overwrites the secret and identity fields of cfg with
+// their CMSWWW_-prefixed environment variable equivalents, when set. This
+// lets operators keep credentials out of cmswwwdataload.conf entirely,
+// 12-factor style; env vars always win over both the config file and
+// command-line flags, since they're the one place a secret can be injected
+// without ever touching disk.
+func applyEnvOverrides(cfg *Config) {
+	if v, ok := os.LookupEnv("CMSWWW_ADMINPASS"); ok {
+		cfg.AdminPass = SecretString(v)
+	}
+	if v, ok := os.LookupEnv("CMSWWW_CONTRACTORPASS"); ok {
+		cfg.ContractorPass = SecretString(v)
+	}
+	if v, ok := os.LookupEnv("CMSWWW_ADMINEMAIL"); ok {
+		cfg.AdminEmail = v
+	}
+	if v, ok := os.LookupEnv("CMSWWW_CONTRACTOREMAIL"); ok {
+		cfg.ContractorEmail = v
+	}
+	if v, ok := os.LookupEnv("CMSWWW_CONTRACTOREXTENDEDPUBLICKEY"); ok {
+		cfg.ContractorExtendedPublicKey = v
+	}
+}
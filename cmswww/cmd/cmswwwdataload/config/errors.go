@@ -0,0 +1,32 @@
+// Copyright (c) 2013-2014 The btcsuite developers
+// Copyright (c) 2015-2018 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package config
+
+import "fmt"
+
+// ConfigError is returned by Load when a configuration value fails
+// validation. Field identifies the offending config option so callers (and
+// error messages) don't have to parse free-form text to find it.
+type ConfigError struct {
+	Field string
+	Err   error
+}
+
+// Error satisfies the error interface.
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Field, e.Err)
+}
+
+// Unwrap allows errors.Is/errors.As to see through to the underlying error.
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// newConfigError wraps err with the name of the field that failed to
+// validate.
+func newConfigError(field string, err error) *ConfigError {
+	return &ConfigError{Field: field, Err: err}
+}
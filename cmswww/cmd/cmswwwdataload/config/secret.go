@@ -0,0 +1,30 @@
+// Copyright (c) 2013-2014 The btcsuite developers
+// Copyright (c) 2015-2018 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package config
+
+// SecretString is a string that must never be printed in full. It
+// implements go-flags' Marshaler so that generated usage text, ini dumps,
+// and any fmt verb that doesn't reach through to the raw string (%v, %s)
+// only ever show "***".
+type SecretString string
+
+// String implements fmt.Stringer, redacting the secret.
+func (s SecretString) String() string {
+	return "***"
+}
+
+// MarshalFlag implements go-flags.Marshaler, redacting the secret when the
+// config is serialized back out (e.g. --help defaults, ini round-trips).
+func (s SecretString) MarshalFlag() (string, error) {
+	return "***", nil
+}
+
+// UnmarshalFlag implements go-flags.Unmarshaler, accepting the secret as
+// plain text from the command line or config file.
+func (s *SecretString) UnmarshalFlag(value string) error {
+	*s = SecretString(value)
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2013-2014 The btcsuite developers
+// Copyright (c) 2015-2018 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"fmt"
+	"math"
+	"net/mail"
+	"unicode"
+)
+
+const (
+	// minPasswordLength is the shortest password Load will accept,
+	// regardless of how the entropy estimate below comes out.
+	minPasswordLength = 8
+
+	// minPasswordEntropyBits is the estimated entropy, in bits, a
+	// password must clear to be considered strong enough. 40 bits is
+	// roughly "toss a mixed-case alphanumeric string of 7+ characters",
+	// in the same ballpark as zxcvbn's "fair" cutoff.
+	minPasswordEntropyBits = 40
+)
+
+// validateEmail returns a *ConfigError if email is not a valid RFC 5322
+// address.
+func validateEmail(field, email string) error {
+	if _, err := mail.ParseAddress(email); err != nil {
+		return newConfigError(field, fmt.Errorf("invalid email address: %v", err))
+	}
+	return nil
+}
+
+// validatePassword returns a *ConfigError if password is shorter than
+// minPasswordLength or its estimated entropy falls below
+// minPasswordEntropyBits.
+func validatePassword(field string, password SecretString) error {
+	s := string(password)
+
+	if len(s) < minPasswordLength {
+		return newConfigError(field, fmt.Errorf(
+			"must be at least %d characters", minPasswordLength))
+	}
+
+	if bits := passwordEntropyBits(s); bits < minPasswordEntropyBits {
+		return newConfigError(field, fmt.Errorf(
+			"too weak (~%.0f bits of entropy, want at least %d)",
+			bits, minPasswordEntropyBits))
+	}
+
+	return nil
+}
+
+// passwordEntropyBits gives a rough, zxcvbn-style entropy estimate for s:
+// the character classes present determine an effective alphabet size, and
+// entropy is len(s) * log2(alphabet size). It does not detect dictionary
+// words or repeated patterns, but it is enough to reject short
+// single-character-class passwords like "password" or "11111111". A
+// single-class password that is long enough (e.g. 9+ lowercase letters)
+// still clears minPasswordEntropyBits.
+func passwordEntropyBits(s string) float64 {
+	var hasLower, hasUpper, hasDigit, hasSymbol bool
+	for _, r := range s {
+		switch {
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		default:
+			hasSymbol = true
+		}
+	}
+
+	var alphabet float64
+	if hasLower {
+		alphabet += 26
+	}
+	if hasUpper {
+		alphabet += 26
+	}
+	if hasDigit {
+		alphabet += 10
+	}
+	if hasSymbol {
+		alphabet += 32
+	}
+	if alphabet == 0 {
+		return 0
+	}
+
+	return float64(len(s)) * math.Log2(alphabet)
+}
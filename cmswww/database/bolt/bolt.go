@@ -0,0 +1,412 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package bolt implements database.Database on top of a local BoltDB file.
+// It is intended for single-node, embedded deployments of cmswww.
+package bolt
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/decred/dcrd/chaincfg"
+
+	"github.com/decred/contractor-mgmt/cmswww/database"
+	"github.com/decred/contractor-mgmt/cmswww/hdwallet"
+	"github.com/decred/contractor-mgmt/cmswww/totp"
+)
+
+const (
+	usersBucket    = "users"
+	userIDsBucket  = "userids" // username/email -> user id
+	metadataBucket = database.MigrationsBucket
+
+	migrationsKey = "migrations"
+)
+
+func init() {
+	database.Register("bolt", New)
+}
+
+// boltdb implements the database.Database interface using a single BoltDB
+// file as storage.
+type boltdb struct {
+	db            *bolt.DB
+	net           *chaincfg.Params
+	addressIsUsed hdwallet.IsUsedFunc
+	gapLimit      uint32
+}
+
+// New opens (creating if necessary) the BoltDB file named by cfg.DSN and
+// returns a database.Database backed by it.
+func New(cfg database.DriverConfig) (database.Database, error) {
+	db, err := bolt.Open(cfg.DSN, 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("open bolt db: %v", err)
+	}
+
+	net := cfg.Net
+	if net == nil {
+		net = &chaincfg.MainNetParams
+	}
+
+	gapLimit := cfg.GapLimit
+	if gapLimit == 0 {
+		gapLimit = database.DefaultGapLimit
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{usersBucket, userIDsBucket, metadataBucket} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("init buckets: %v", err)
+	}
+
+	return &boltdb{
+		db:            db,
+		net:           net,
+		addressIsUsed: cfg.AddressIsUsed,
+		gapLimit:      gapLimit,
+	}, nil
+}
+
+// emailIndexKey returns the userIDsBucket key under which email's user ID
+// is indexed.
+func emailIndexKey(email string) []byte {
+	return []byte("email:" + email)
+}
+
+// usernameIndexKey returns the userIDsBucket key under which username's
+// user ID is indexed.
+func usernameIndexKey(username string) []byte {
+	return []byte("username:" + username)
+}
+
+// UserGet returns the user record for the given email.
+func (b *boltdb) UserGet(email string) (*database.User, error) {
+	return b.userByIndexKey(emailIndexKey(email))
+}
+
+// UserGetByUsername returns the user record for the given username.
+func (b *boltdb) UserGetByUsername(username string) (*database.User, error) {
+	return b.userByIndexKey(usernameIndexKey(username))
+}
+
+// UserGetById returns the user record for the given id.
+func (b *boltdb) UserGetById(id uint64) (*database.User, error) {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return b.userByKey(key)
+}
+
+// userByIndexKey resolves indexKey to a user ID via userIDsBucket and
+// returns that user's record.
+func (b *boltdb) userByIndexKey(indexKey []byte) (*database.User, error) {
+	var u database.User
+	err := b.db.View(func(tx *bolt.Tx) error {
+		key := tx.Bucket([]byte(userIDsBucket)).Get(indexKey)
+		if key == nil {
+			return database.ErrUserNotFound
+		}
+		v := tx.Bucket([]byte(usersBucket)).Get(key)
+		if v == nil {
+			return database.ErrUserNotFound
+		}
+		return json.Unmarshal(v, &u)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (b *boltdb) userByKey(key []byte) (*database.User, error) {
+	var u database.User
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(usersBucket)).Get(key)
+		if v == nil {
+			return database.ErrUserNotFound
+		}
+		return json.Unmarshal(v, &u)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// UserNew adds a new user.
+func (b *boltdb) UserNew(u database.User) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket([]byte(usersBucket))
+		ids := tx.Bucket([]byte(userIDsBucket))
+
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, u.ID)
+		if v := users.Get(key); v != nil {
+			return database.ErrUserExists
+		}
+
+		emailKey := emailIndexKey(u.Email)
+		usernameKey := usernameIndexKey(u.Username)
+		if ids.Get(emailKey) != nil || ids.Get(usernameKey) != nil {
+			return database.ErrUserExists
+		}
+
+		v, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+		if err := users.Put(key, v); err != nil {
+			return err
+		}
+		if err := ids.Put(emailKey, key); err != nil {
+			return err
+		}
+		return ids.Put(usernameKey, key)
+	})
+}
+
+// UserUpdate updates an existing user.
+func (b *boltdb) UserUpdate(u database.User) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket([]byte(usersBucket))
+		ids := tx.Bucket([]byte(userIDsBucket))
+
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, u.ID)
+		old := users.Get(key)
+		if old == nil {
+			return database.ErrUserNotFound
+		}
+
+		var oldUser database.User
+		if err := json.Unmarshal(old, &oldUser); err != nil {
+			return err
+		}
+
+		v, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+		if err := users.Put(key, v); err != nil {
+			return err
+		}
+
+		if oldUser.Email != u.Email {
+			if err := ids.Delete(emailIndexKey(oldUser.Email)); err != nil {
+				return err
+			}
+			if err := ids.Put(emailIndexKey(u.Email), key); err != nil {
+				return err
+			}
+		}
+		if oldUser.Username != u.Username {
+			if err := ids.Delete(usernameIndexKey(oldUser.Username)); err != nil {
+				return err
+			}
+			if err := ids.Put(usernameIndexKey(u.Username), key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// AllUsers iterates over every user record, invoking callbackFn for each.
+func (b *boltdb) AllUsers(callbackFn func(u *database.User)) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(usersBucket)).ForEach(func(k, v []byte) error {
+			var u database.User
+			if err := json.Unmarshal(v, &u); err != nil {
+				return err
+			}
+			callbackFn(&u)
+			return nil
+		})
+	})
+}
+
+// NextPayoutAddress derives the next unused payout address for the user's
+// PayoutXPub, advancing and persisting PayoutAddressIndex. If the index is
+// still at its zero value and the driver was configured with
+// DriverConfig.AddressIsUsed, it first recovers the real next-unused index
+// via a gap-limit rescan, in case PayoutAddressIndex was lost or the
+// wallet was restored from seed.
+func (b *boltdb) NextPayoutAddress(userID uint64) (string, error) {
+	var addr string
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket([]byte(usersBucket))
+
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, userID)
+		v := users.Get(key)
+		if v == nil {
+			return database.ErrUserNotFound
+		}
+
+		var u database.User
+		if err := json.Unmarshal(v, &u); err != nil {
+			return err
+		}
+
+		w, err := hdwallet.New(u.PayoutXPub, b.net)
+		if err != nil {
+			return fmt.Errorf("parse payout xpub: %v", err)
+		}
+
+		index := u.PayoutAddressIndex
+		if index == 0 && b.addressIsUsed != nil {
+			recovered, err := hdwallet.FindNextUnused(w, b.addressIsUsed, b.gapLimit)
+			if err != nil {
+				return fmt.Errorf("gap-limit rescan: %v", err)
+			}
+			index = recovered
+		}
+
+		a, err := w.AddressAt(index)
+		if err != nil {
+			return err
+		}
+		addr = a
+
+		u.PayoutAddressIndex = index + 1
+		nv, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+		return users.Put(key, nv)
+	})
+	return addr, err
+}
+
+// EnableTOTP stores the user's (already encrypted) TOTP secret and backup
+// code hashes, and marks TOTP enabled.
+func (b *boltdb) EnableTOTP(userID uint64, secret []byte, backupCodeHashes [][]byte) error {
+	return b.updateUser(userID, func(u *database.User) {
+		u.TOTPSecret = secret
+		u.TOTPEnabled = true
+		u.TOTPConfirmedAt = time.Now().Unix()
+		u.TOTPLastUsedCounter = 0
+		u.BackupCodes = backupCodeHashes
+	})
+}
+
+// ConsumeBackupCode checks code against the user's BackupCodes, removing
+// it if it matches so it cannot be reused.
+func (b *boltdb) ConsumeBackupCode(userID uint64, code string) error {
+	var matched bool
+	err := b.updateUser(userID, func(u *database.User) {
+		i := totp.MatchBackupCode(u.BackupCodes, code)
+		if i < 0 {
+			return
+		}
+		matched = true
+		u.BackupCodes = append(u.BackupCodes[:i], u.BackupCodes[i+1:]...)
+	})
+	if err != nil {
+		return err
+	}
+	if !matched {
+		return database.ErrInvalidBackupCode
+	}
+	return nil
+}
+
+// DisableTOTP clears a user's second-factor configuration.
+func (b *boltdb) DisableTOTP(userID uint64) error {
+	return b.updateUser(userID, func(u *database.User) {
+		u.TOTPSecret = nil
+		u.TOTPEnabled = false
+		u.TOTPConfirmedAt = 0
+		u.TOTPLastUsedCounter = 0
+		u.BackupCodes = nil
+	})
+}
+
+// updateUser loads the user record for userID, applies mutate, and writes
+// the result back in a single transaction.
+func (b *boltdb) updateUser(userID uint64, mutate func(u *database.User)) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket([]byte(usersBucket))
+
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, userID)
+		v := users.Get(key)
+		if v == nil {
+			return database.ErrUserNotFound
+		}
+
+		var u database.User
+		if err := json.Unmarshal(v, &u); err != nil {
+			return err
+		}
+
+		mutate(&u)
+
+		nv, err := json.Marshal(u)
+		if err != nil {
+			return err
+		}
+		return users.Put(key, nv)
+	})
+}
+
+// Migrate brings the bucket layout up to date, recording applied
+// migrations in the metadata bucket so repeated calls are cheap no-ops.
+func (b *boltdb) Migrate() error {
+	return database.RunMigrations(b, b.db, migrations)
+}
+
+// AppliedMigrations implements database.MigrationRecorder.
+func (b *boltdb) AppliedMigrations() ([]int, error) {
+	var ids []int
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(metadataBucket)).Get([]byte(migrationsKey))
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &ids)
+	})
+	return ids, err
+}
+
+// RecordMigration implements database.MigrationRecorder.
+func (b *boltdb) RecordMigration(id int) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		meta := tx.Bucket([]byte(metadataBucket))
+
+		var ids []int
+		if v := meta.Get([]byte(migrationsKey)); v != nil {
+			if err := json.Unmarshal(v, &ids); err != nil {
+				return err
+			}
+		}
+		ids = append(ids, id)
+
+		v, err := json.Marshal(ids)
+		if err != nil {
+			return err
+		}
+		return meta.Put([]byte(migrationsKey), v)
+	})
+}
+
+// Close closes the underlying BoltDB file.
+func (b *boltdb) Close() error {
+	return b.db.Close()
+}
+
+// migrations is the ordered list of schema changes applied to a BoltDB
+// store.  New migrations must be appended, never reordered or removed.
+var migrations = []database.Migration{}
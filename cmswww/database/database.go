@@ -8,7 +8,10 @@ import (
 	"encoding/hex"
 	"errors"
 
+	"github.com/decred/dcrd/chaincfg"
 	"github.com/decred/politeia/politeiad/api/v1/identity"
+
+	"github.com/decred/contractor-mgmt/cmswww/hdwallet"
 )
 
 var (
@@ -24,6 +27,18 @@ var (
 
 	// ErrShutdown is emitted when the database is shutting down.
 	ErrShutdown = errors.New("database is shutting down")
+
+	// ErrDriverNotFound indicates that no driver was registered under the
+	// requested name.
+	ErrDriverNotFound = errors.New("database driver not found")
+
+	// ErrDriverExists indicates that a driver was already registered under
+	// the given name.
+	ErrDriverExists = errors.New("database driver already registered")
+
+	// ErrInvalidBackupCode indicates that a supplied TOTP backup code
+	// does not match any of the user's remaining unused codes.
+	ErrInvalidBackupCode = errors.New("invalid backup code")
 )
 
 // Identity wraps an ed25519 public key and timestamps to indicate if it is
@@ -76,8 +91,69 @@ type User struct {
 	// active key at a time.  We allow multiples in order to deal with key
 	// loss.
 	Identities []Identity
+
+	// PayoutXPub is the contractor-supplied BIP32 extended public key that
+	// per-invoice payout addresses are derived from.  PayoutAddressIndex is
+	// the next unused external-chain index to derive from it; it is
+	// advanced every time NextPayoutAddress is called.
+	PayoutXPub         string
+	PayoutAddressIndex uint32
+
+	// TOTPSecret is the user's RFC 6238 secret, encrypted at rest with the
+	// server's DriverConfig.EncryptionKey via EncryptSecret.  TOTPEnabled
+	// is only set once the user has confirmed a code against the secret;
+	// TOTPConfirmedAt records when that happened.  TOTPLastUsedCounter is
+	// the most recent time-step counter accepted for this user, to refuse
+	// replays of an already-used code.
+	TOTPSecret          []byte
+	TOTPEnabled         bool
+	TOTPConfirmedAt     int64
+	TOTPLastUsedCounter uint64
+
+	// BackupCodes are bcrypt hashes of unused one-time codes a user can
+	// log in with if they lose access to their TOTP device.  Each code is
+	// removed from this slice the moment it is consumed.
+	BackupCodes [][]byte
+}
+
+// DriverConfig carries the settings needed to open a concrete Database
+// backend and is handed to the factory registered for Type.  Nothing in
+// this repository fills it in from a config file; callers of New build it
+// themselves.
+//
+// TLSCert, TLSKey and TLSCA are not read by the in-tree drivers: the
+// postgres driver takes its TLS settings only from the DSN.
+type DriverConfig struct {
+	Type    string           // Registered driver name, e.g. "bolt" or "postgres"
+	DSN     string           // Data source name / connection string / file path
+	TLSCert string           // Path to client TLS certificate
+	TLSKey  string           // Path to client TLS key
+	TLSCA   string           // Path to CA certificate used to verify the server
+	Net     *chaincfg.Params // Network payout addresses are derived for
+
+	// EncryptionKey is the server-held AES-256 key used to encrypt
+	// User.TOTPSecret at rest; see EncryptSecret/DecryptSecret.
+	EncryptionKey []byte
+
+	// AddressIsUsed optionally reports whether a payout address has ever
+	// been handed out or seen on chain. When set, NextPayoutAddress uses
+	// it to recover the correct next-unused index via a gap-limit rescan
+	// the first time it is called for a user whose PayoutAddressIndex is
+	// still at its zero value (e.g. the wallet was restored from seed
+	// and the persisted index was lost). When nil, the persisted index
+	// is trusted as-is.
+	AddressIsUsed hdwallet.IsUsedFunc
+
+	// GapLimit is the window size AddressIsUsed's rescan requires to be
+	// clear before concluding an index is unused. Zero means
+	// DefaultGapLimit.
+	GapLimit uint32
 }
 
+// DefaultGapLimit is the gap limit used by NextPayoutAddress's rescan when
+// DriverConfig.GapLimit is left at its zero value.
+const DefaultGapLimit = 20
+
 // Database interface that is required by the web server.
 type Database interface {
 	// User functions
@@ -88,6 +164,32 @@ type Database interface {
 	UserUpdate(User) error                   // Update existing user
 	AllUsers(callbackFn func(u *User)) error // Iterate all users
 
+	// NextPayoutAddress derives and returns the next unused payout address
+	// for the given user's PayoutXPub, persisting the advanced
+	// PayoutAddressIndex so the same address is never handed out twice.
+	NextPayoutAddress(userID uint64) (string, error)
+
+	// EnableTOTP stores secret (already encrypted by the caller with
+	// EncryptSecret) and backupCodeHashes (as returned by
+	// totp.GenerateBackupCodes) against userID, marks TOTP enabled, and
+	// records TOTPConfirmedAt as the current time.
+	EnableTOTP(userID uint64, secret []byte, backupCodeHashes [][]byte) error
+
+	// ConsumeBackupCode checks code against userID's BackupCodes and, if
+	// it matches one, removes it so it cannot be used again. It returns
+	// ErrInvalidBackupCode if code does not match any stored hash.
+	ConsumeBackupCode(userID uint64, code string) error
+
+	// DisableTOTP clears a user's TOTP secret, confirmation time, last
+	// used counter, and backup codes, turning second-factor login off.
+	DisableTOTP(userID uint64) error
+
+	// Migrate brings the backend's schema up to the latest version known
+	// to the driver, recording each applied migration so that Migrate is
+	// idempotent across restarts.  The driver determines which migrations
+	// are outstanding itself, from its own recorded migration history.
+	Migrate() error
+
 	// Close performs cleanup of the backend.
 	Close() error
-}
\ No newline at end of file
+}
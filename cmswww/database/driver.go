@@ -0,0 +1,53 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package database
+
+import (
+	"fmt"
+	"sync"
+)
+
+// DriverFactory builds a Database for the given config.  Drivers register
+// one of these with Register under a unique name, typically from an init
+// function in the driver's package.
+type DriverFactory func(cfg DriverConfig) (Database, error)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]DriverFactory)
+)
+
+// Register makes a database driver available by the provided name.  It is
+// intended to be called from the init function of a driver package, e.g.
+//
+//	func init() {
+//		database.Register("bolt", New)
+//	}
+//
+// Register panics if called twice with the same name or if factory is nil.
+func Register(name string, factory DriverFactory) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	if factory == nil {
+		panic("database: Register factory is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("database: Register called twice for driver " + name)
+	}
+	drivers[name] = factory
+}
+
+// New opens a Database using the driver named by cfg.Type.
+func New(cfg DriverConfig) (Database, error) {
+	driversMu.RLock()
+	factory, ok := drivers[cfg.Type]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%v: %w", cfg.Type, ErrDriverNotFound)
+	}
+
+	return factory(cfg)
+}
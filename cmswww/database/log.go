@@ -0,0 +1,18 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package database
+
+import "github.com/decred/slog"
+
+// log is the subsystem logger used by the database package and its
+// drivers. It is disabled by default so that importers who never call
+// UseLogger see no output.
+var log = slog.Disabled
+
+// UseLogger sets the subsystem logger used by the database package and its
+// registered drivers. This should be called before opening a Database.
+func UseLogger(logger slog.Logger) {
+	log = logger
+}
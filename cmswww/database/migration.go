@@ -0,0 +1,67 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package database
+
+import "fmt"
+
+// MigrationsBucket is the well-known name drivers should
+// use for the bucket (BoltDB) or table (PostgreSQL) that records which
+// migrations have been applied.
+const MigrationsBucket = "metadata"
+
+// Migration describes a single, irreversible schema change.  ID must be
+// unique and monotonically increasing; Up is invoked with the driver's own
+// storage handle (an *bolt.DB, a *sql.DB, ...) cast by the caller.
+type Migration struct {
+	ID   int
+	Name string
+	Up   func(store interface{}) error
+}
+
+// MigrationRecorder is implemented by a driver's metadata bucket/table so
+// that RunMigrations can figure out which migrations still need to run and
+// record the ones it applies.
+type MigrationRecorder interface {
+	// AppliedMigrations returns the IDs of migrations that have already
+	// been applied, in no particular order.
+	AppliedMigrations() ([]int, error)
+
+	// RecordMigration marks the migration with the given ID as applied.
+	RecordMigration(id int) error
+}
+
+// RunMigrations applies, in the order they appear in migrations (it does
+// not sort them by ID), every migration that has not yet been recorded by
+// recorder, passing store through to each
+// migration's Up function.  It stops and returns an error on the first
+// migration that fails, leaving already-applied migrations recorded.
+func RunMigrations(recorder MigrationRecorder, store interface{}, migrations []Migration) error {
+	applied, err := recorder.AppliedMigrations()
+	if err != nil {
+		return fmt.Errorf("load applied migrations: %v", err)
+	}
+
+	done := make(map[int]bool, len(applied))
+	for _, id := range applied {
+		done[id] = true
+	}
+
+	for _, m := range migrations {
+		if done[m.ID] {
+			continue
+		}
+
+		log.Infof("applying migration %d: %s", m.ID, m.Name)
+
+		if err := m.Up(store); err != nil {
+			return fmt.Errorf("migration %d (%s): %v", m.ID, m.Name, err)
+		}
+		if err := recorder.RecordMigration(m.ID); err != nil {
+			return fmt.Errorf("record migration %d (%s): %v", m.ID, m.Name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,470 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package postgres implements database.Database on top of PostgreSQL. It is
+// intended for multi-node deployments of cmswww where several front ends
+// share a single backend.
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/decred/dcrd/chaincfg"
+
+	"github.com/decred/contractor-mgmt/cmswww/database"
+	"github.com/decred/contractor-mgmt/cmswww/hdwallet"
+	"github.com/decred/contractor-mgmt/cmswww/totp"
+)
+
+const migrationsTable = database.MigrationsBucket
+
+func init() {
+	database.Register("postgres", New)
+}
+
+// postgresdb implements the database.Database interface on top of a
+// *sql.DB connected to a PostgreSQL server.
+type postgresdb struct {
+	db            *sql.DB
+	net           *chaincfg.Params
+	addressIsUsed hdwallet.IsUsedFunc
+	gapLimit      uint32
+}
+
+// New opens a connection to the PostgreSQL server described by cfg and
+// returns a database.Database backed by it.  cfg.DSN is passed directly to
+// database/sql as the connection string; cfg.TLSCert/TLSKey/TLSCA should be
+// embedded in the DSN (e.g. sslmode=verify-full&sslcert=...) as lib/pq does
+// not take them separately.
+func New(cfg database.DriverConfig) (database.Database, error) {
+	db, err := sql.Open("postgres", cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("open postgres db: %v", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("ping postgres db: %v", err)
+	}
+
+	net := cfg.Net
+	if net == nil {
+		net = &chaincfg.MainNetParams
+	}
+
+	gapLimit := cfg.GapLimit
+	if gapLimit == 0 {
+		gapLimit = database.DefaultGapLimit
+	}
+
+	p := &postgresdb{
+		db:            db,
+		net:           net,
+		addressIsUsed: cfg.AddressIsUsed,
+		gapLimit:      gapLimit,
+	}
+	if err := p.ensureMetadataTable(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *postgresdb) ensureMetadataTable() error {
+	_, err := p.db.Exec(`CREATE TABLE IF NOT EXISTS ` + migrationsTable + ` (
+		migration_id INTEGER PRIMARY KEY
+	)`)
+	return err
+}
+
+// UserGet returns the user record for the given email.
+func (p *postgresdb) UserGet(email string) (*database.User, error) {
+	return p.userByQuery(`SELECT id, email, username, hashed_password, admin,
+		register_verification_token, register_verification_expiry,
+		update_identity_verification_token, update_identity_verification_expiry,
+		last_login, failed_login_attempts, identities, payout_xpub,
+		payout_address_index, totp_secret, totp_enabled, totp_confirmed_at,
+		totp_last_used_counter, backup_codes
+		FROM users WHERE email = $1`, email)
+}
+
+// UserGetByUsername returns the user record for the given username.
+func (p *postgresdb) UserGetByUsername(username string) (*database.User, error) {
+	return p.userByQuery(`SELECT id, email, username, hashed_password, admin,
+		register_verification_token, register_verification_expiry,
+		update_identity_verification_token, update_identity_verification_expiry,
+		last_login, failed_login_attempts, identities, payout_xpub,
+		payout_address_index, totp_secret, totp_enabled, totp_confirmed_at,
+		totp_last_used_counter, backup_codes
+		FROM users WHERE username = $1`, username)
+}
+
+// UserGetById returns the user record for the given id.
+func (p *postgresdb) UserGetById(id uint64) (*database.User, error) {
+	return p.userByQuery(`SELECT id, email, username, hashed_password, admin,
+		register_verification_token, register_verification_expiry,
+		update_identity_verification_token, update_identity_verification_expiry,
+		last_login, failed_login_attempts, identities, payout_xpub,
+		payout_address_index, totp_secret, totp_enabled, totp_confirmed_at,
+		totp_last_used_counter, backup_codes
+		FROM users WHERE id = $1`, id)
+}
+
+func (p *postgresdb) userByQuery(query string, arg interface{}) (*database.User, error) {
+	var u database.User
+	var identities []byte
+	row := p.db.QueryRow(query, arg)
+	err := row.Scan(&u.ID, &u.Email, &u.Username, &u.HashedPassword, &u.Admin,
+		&u.RegisterVerificationToken, &u.RegisterVerificationExpiry,
+		&u.UpdateIdentityVerificationToken, &u.UpdateIdentityVerificationExpiry,
+		&u.LastLogin, &u.FailedLoginAttempts, &identities, &u.PayoutXPub,
+		&u.PayoutAddressIndex, &u.TOTPSecret, &u.TOTPEnabled, &u.TOTPConfirmedAt,
+		&u.TOTPLastUsedCounter, pq.Array(&u.BackupCodes))
+	if err == sql.ErrNoRows {
+		return nil, database.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if u.Identities, err = unmarshalIdentities(identities); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// UserNew adds a new user.
+func (p *postgresdb) UserNew(u database.User) error {
+	identities, err := marshalIdentities(u.Identities)
+	if err != nil {
+		return err
+	}
+	_, err = p.db.Exec(`INSERT INTO users (id, email, username,
+		hashed_password, admin, register_verification_token,
+		register_verification_expiry, update_identity_verification_token,
+		update_identity_verification_expiry, last_login, failed_login_attempts,
+		identities, payout_xpub, payout_address_index, totp_secret,
+		totp_enabled, totp_confirmed_at, totp_last_used_counter, backup_codes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14,
+			$15, $16, $17, $18, $19)`,
+		u.ID, u.Email, u.Username, u.HashedPassword, u.Admin,
+		u.RegisterVerificationToken, u.RegisterVerificationExpiry,
+		u.UpdateIdentityVerificationToken, u.UpdateIdentityVerificationExpiry,
+		u.LastLogin, u.FailedLoginAttempts, identities, u.PayoutXPub,
+		u.PayoutAddressIndex, u.TOTPSecret, u.TOTPEnabled, u.TOTPConfirmedAt,
+		u.TOTPLastUsedCounter, pq.Array(u.BackupCodes))
+	return err
+}
+
+// UserUpdate updates an existing user.
+func (p *postgresdb) UserUpdate(u database.User) error {
+	identities, err := marshalIdentities(u.Identities)
+	if err != nil {
+		return err
+	}
+	result, err := p.db.Exec(`UPDATE users SET email = $2, username = $3,
+		hashed_password = $4, admin = $5, register_verification_token = $6,
+		register_verification_expiry = $7, update_identity_verification_token = $8,
+		update_identity_verification_expiry = $9, last_login = $10,
+		failed_login_attempts = $11, identities = $12, payout_xpub = $13,
+		payout_address_index = $14, totp_secret = $15, totp_enabled = $16,
+		totp_confirmed_at = $17, totp_last_used_counter = $18, backup_codes = $19
+		WHERE id = $1`,
+		u.ID, u.Email, u.Username, u.HashedPassword, u.Admin,
+		u.RegisterVerificationToken, u.RegisterVerificationExpiry,
+		u.UpdateIdentityVerificationToken, u.UpdateIdentityVerificationExpiry,
+		u.LastLogin, u.FailedLoginAttempts, identities, u.PayoutXPub,
+		u.PayoutAddressIndex, u.TOTPSecret, u.TOTPEnabled, u.TOTPConfirmedAt,
+		u.TOTPLastUsedCounter, pq.Array(u.BackupCodes))
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return database.ErrUserNotFound
+	}
+	return nil
+}
+
+// AllUsers iterates over every user record, invoking callbackFn for each.
+func (p *postgresdb) AllUsers(callbackFn func(u *database.User)) error {
+	rows, err := p.db.Query(`SELECT id, email, username, hashed_password,
+		admin, register_verification_token, register_verification_expiry,
+		update_identity_verification_token, update_identity_verification_expiry,
+		last_login, failed_login_attempts, identities, payout_xpub,
+		payout_address_index, totp_secret, totp_enabled, totp_confirmed_at,
+		totp_last_used_counter, backup_codes
+		FROM users`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u database.User
+		var identities []byte
+		err := rows.Scan(&u.ID, &u.Email, &u.Username, &u.HashedPassword, &u.Admin,
+			&u.RegisterVerificationToken, &u.RegisterVerificationExpiry,
+			&u.UpdateIdentityVerificationToken, &u.UpdateIdentityVerificationExpiry,
+			&u.LastLogin, &u.FailedLoginAttempts, &identities, &u.PayoutXPub,
+			&u.PayoutAddressIndex, &u.TOTPSecret, &u.TOTPEnabled, &u.TOTPConfirmedAt,
+			&u.TOTPLastUsedCounter, pq.Array(&u.BackupCodes))
+		if err != nil {
+			return err
+		}
+		if u.Identities, err = unmarshalIdentities(identities); err != nil {
+			return err
+		}
+		callbackFn(&u)
+	}
+	return rows.Err()
+}
+
+// NextPayoutAddress derives the next unused payout address for the user's
+// PayoutXPub, advancing and persisting PayoutAddressIndex. If the index is
+// still at its zero value and the driver was configured with
+// DriverConfig.AddressIsUsed, it first recovers the real next-unused index
+// via a gap-limit rescan, in case PayoutAddressIndex was lost or the
+// wallet was restored from seed.
+func (p *postgresdb) NextPayoutAddress(userID uint64) (string, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var xpub string
+	var index uint32
+	row := tx.QueryRow(`SELECT payout_xpub, payout_address_index
+		FROM users WHERE id = $1 FOR UPDATE`, userID)
+	if err := row.Scan(&xpub, &index); err != nil {
+		if err == sql.ErrNoRows {
+			return "", database.ErrUserNotFound
+		}
+		return "", err
+	}
+
+	w, err := hdwallet.New(xpub, p.net)
+	if err != nil {
+		return "", fmt.Errorf("parse payout xpub: %v", err)
+	}
+
+	if index == 0 && p.addressIsUsed != nil {
+		recovered, err := hdwallet.FindNextUnused(w, p.addressIsUsed, p.gapLimit)
+		if err != nil {
+			return "", fmt.Errorf("gap-limit rescan: %v", err)
+		}
+		index = recovered
+	}
+
+	addr, err := w.AddressAt(index)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(`UPDATE users SET payout_address_index = $2
+		WHERE id = $1`, userID, index+1)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	return addr, nil
+}
+
+// EnableTOTP stores the user's (already encrypted) TOTP secret and backup
+// code hashes, and marks TOTP enabled.
+func (p *postgresdb) EnableTOTP(userID uint64, secret []byte, backupCodeHashes [][]byte) error {
+	result, err := p.db.Exec(`UPDATE users SET totp_secret = $2,
+		totp_enabled = TRUE, totp_confirmed_at = $3, totp_last_used_counter = 0,
+		backup_codes = $4
+		WHERE id = $1`, userID, secret, time.Now().Unix(), pq.Array(backupCodeHashes))
+	if err != nil {
+		return err
+	}
+	return requireRowAffected(result)
+}
+
+// ConsumeBackupCode checks code against the user's BackupCodes, removing
+// it if it matches so it cannot be reused. The read-modify-write is done
+// inside a single transaction with a row lock so two concurrent logins
+// can never both consume the same code.
+func (p *postgresdb) ConsumeBackupCode(userID uint64, code string) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var hashes [][]byte
+	row := tx.QueryRow(`SELECT backup_codes FROM users WHERE id = $1 FOR UPDATE`, userID)
+	if err := row.Scan(pq.Array(&hashes)); err != nil {
+		if err == sql.ErrNoRows {
+			return database.ErrUserNotFound
+		}
+		return err
+	}
+
+	i := totp.MatchBackupCode(hashes, code)
+	if i < 0 {
+		return database.ErrInvalidBackupCode
+	}
+	hashes = append(hashes[:i], hashes[i+1:]...)
+
+	if _, err := tx.Exec(`UPDATE users SET backup_codes = $2 WHERE id = $1`,
+		userID, pq.Array(hashes)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DisableTOTP clears a user's second-factor configuration.
+func (p *postgresdb) DisableTOTP(userID uint64) error {
+	result, err := p.db.Exec(`UPDATE users SET totp_secret = NULL,
+		totp_enabled = FALSE, totp_confirmed_at = 0, totp_last_used_counter = 0,
+		backup_codes = '{}' WHERE id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	return requireRowAffected(result)
+}
+
+func requireRowAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return database.ErrUserNotFound
+	}
+	return nil
+}
+
+// marshalIdentities encodes a user's identities as JSON for storage in the
+// identities column.
+func marshalIdentities(identities []database.Identity) ([]byte, error) {
+	return json.Marshal(identities)
+}
+
+// unmarshalIdentities decodes the identities column back into a user's
+// identities. raw is nil for rows written before the identities column
+// existed, in which case the user has no recorded identities.
+func unmarshalIdentities(raw []byte) ([]database.Identity, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	var identities []database.Identity
+	if err := json.Unmarshal(raw, &identities); err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+// Migrate brings the schema up to date, recording applied migrations in
+// the metadata table so repeated calls are cheap no-ops.
+func (p *postgresdb) Migrate() error {
+	return database.RunMigrations(p, p.db, migrations)
+}
+
+// AppliedMigrations implements database.MigrationRecorder.
+func (p *postgresdb) AppliedMigrations() ([]int, error) {
+	rows, err := p.db.Query(`SELECT migration_id FROM ` + migrationsTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// RecordMigration implements database.MigrationRecorder.
+func (p *postgresdb) RecordMigration(id int) error {
+	_, err := p.db.Exec(`INSERT INTO `+migrationsTable+` (migration_id)
+		VALUES ($1)`, id)
+	return err
+}
+
+// Close closes the underlying database connection.
+func (p *postgresdb) Close() error {
+	return p.db.Close()
+}
+
+// migrations is the ordered list of schema changes applied to a PostgreSQL
+// database.  New migrations must be appended, never reordered or removed.
+var migrations = []database.Migration{
+	{
+		ID:   1,
+		Name: "create users table",
+		Up: func(store interface{}) error {
+			db := store.(*sql.DB)
+			_, err := db.Exec(`CREATE TABLE IF NOT EXISTS users (
+				id BIGINT PRIMARY KEY,
+				email TEXT NOT NULL UNIQUE,
+				username TEXT NOT NULL UNIQUE,
+				hashed_password BYTEA NOT NULL,
+				admin BOOLEAN NOT NULL DEFAULT FALSE,
+				register_verification_token BYTEA,
+				register_verification_expiry BIGINT NOT NULL DEFAULT 0,
+				update_identity_verification_token BYTEA,
+				update_identity_verification_expiry BIGINT NOT NULL DEFAULT 0,
+				last_login BIGINT NOT NULL DEFAULT 0,
+				failed_login_attempts BIGINT NOT NULL DEFAULT 0
+			)`)
+			return err
+		},
+	},
+	{
+		ID:   2,
+		Name: "add payout columns",
+		Up: func(store interface{}) error {
+			db := store.(*sql.DB)
+			_, err := db.Exec(`ALTER TABLE users
+				ADD COLUMN IF NOT EXISTS payout_xpub TEXT NOT NULL DEFAULT '',
+				ADD COLUMN IF NOT EXISTS payout_address_index BIGINT NOT NULL DEFAULT 0`)
+			return err
+		},
+	},
+	{
+		ID:   3,
+		Name: "add totp columns",
+		Up: func(store interface{}) error {
+			db := store.(*sql.DB)
+			_, err := db.Exec(`ALTER TABLE users
+				ADD COLUMN IF NOT EXISTS totp_secret BYTEA,
+				ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+				ADD COLUMN IF NOT EXISTS totp_confirmed_at BIGINT NOT NULL DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS totp_last_used_counter BIGINT NOT NULL DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS backup_codes BYTEA[] NOT NULL DEFAULT '{}'`)
+			return err
+		},
+	},
+	{
+		ID:   4,
+		Name: "add identities column",
+		Up: func(store interface{}) error {
+			db := store.(*sql.DB)
+			_, err := db.Exec(`ALTER TABLE users
+				ADD COLUMN IF NOT EXISTS identities BYTEA`)
+			return err
+		},
+	},
+}
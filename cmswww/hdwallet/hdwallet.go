@@ -0,0 +1,72 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package hdwallet derives per-contractor payout addresses from a BIP32
+// extended public key.  Only the external (non-change) chain is used,
+// since contractors never need to see the derivation of refund/change
+// addresses for an xpub they did not generate themselves.
+package hdwallet
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/decred/dcrd/chaincfg"
+	"github.com/decred/dcrd/hdkeychain"
+)
+
+var (
+	// ErrPrivateKey indicates that the supplied extended key encodes a
+	// private key rather than a public one.  Wallets must only ever be
+	// constructed from an xpub so that the server can never spend funds.
+	ErrPrivateKey = errors.New("extended key must be public, not private")
+
+	// ErrWrongNetwork indicates that the supplied extended key was
+	// encoded for a different network than the one requested.
+	ErrWrongNetwork = errors.New("extended key is not for the requested network")
+)
+
+// Wallet derives addresses along the external chain of a single extended
+// public key.
+type Wallet struct {
+	externalChain *hdkeychain.ExtendedKey
+	net           *chaincfg.Params
+}
+
+// New parses xpub as a BIP32 extended public key for the given network and
+// returns a Wallet that derives addresses from its external (0) chain.
+func New(xpub string, params *chaincfg.Params) (*Wallet, error) {
+	key, err := hdkeychain.NewKeyFromString(xpub)
+	if err != nil {
+		return nil, fmt.Errorf("parse extended key: %v", err)
+	}
+	if !key.IsForNet(params) {
+		return nil, ErrWrongNetwork
+	}
+	if key.IsPrivate() {
+		return nil, ErrPrivateKey
+	}
+
+	external, err := key.Child(0)
+	if err != nil {
+		return nil, fmt.Errorf("derive external chain: %v", err)
+	}
+
+	return &Wallet{externalChain: external, net: params}, nil
+}
+
+// AddressAt derives the external-chain address at the given index.
+func (w *Wallet) AddressAt(index uint32) (string, error) {
+	child, err := w.externalChain.Child(index)
+	if err != nil {
+		return "", fmt.Errorf("derive child %d: %v", index, err)
+	}
+
+	addr, err := child.Address(w.net)
+	if err != nil {
+		return "", fmt.Errorf("address for child %d: %v", index, err)
+	}
+
+	return addr.EncodeAddress(), nil
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package hdwallet
+
+// IsUsedFunc reports whether an address has ever been used (e.g. handed
+// out to a contractor or seen on chain). Callers supply their own
+// implementation backed by whatever store or chain query is appropriate.
+type IsUsedFunc func(address string) (bool, error)
+
+// FindNextUnused recovers the next unused external-chain index for w when
+// the locally persisted PayoutAddressIndex has been lost, e.g. because the
+// wallet was restored from seed. It probes indices 1, 2, 4, 8, ... doubling
+// each time until it finds a window of gapLimit consecutive unused
+// addresses, then binary-searches back through the doubling range to find
+// the exact boundary. This runs in O(log n) calls to isUsed rather than a
+// linear scan from zero.
+func FindNextUnused(w *Wallet, isUsed IsUsedFunc, gapLimit uint32) (uint32, error) {
+	if gapLimit == 0 {
+		gapLimit = 1
+	}
+
+	// Exponential phase: lo is the last probed index whose window was
+	// found in use (or 0 if the very first window, at index 1, is clear);
+	// hi is the first probed index at which a full gap-limit window is
+	// clear.
+	var lo uint32
+	hi := uint32(1)
+	for {
+		clear, err := windowUnused(w, isUsed, hi, gapLimit)
+		if err != nil {
+			return 0, err
+		}
+		if clear {
+			break
+		}
+		lo = hi
+		hi *= 2
+	}
+
+	// Binary-search phase: narrow (lo, hi] down to the first unused
+	// index, relying on usage being monotonic (everything below the
+	// boundary used, everything at or above it unused).
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+
+		addr, err := w.AddressAt(mid)
+		if err != nil {
+			return 0, err
+		}
+		used, err := isUsed(addr)
+		if err != nil {
+			return 0, err
+		}
+
+		if used {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	return lo, nil
+}
+
+// windowUnused reports whether every address in [start, start+gapLimit) is
+// unused.
+func windowUnused(w *Wallet, isUsed IsUsedFunc, start, gapLimit uint32) (bool, error) {
+	for i := uint32(0); i < gapLimit; i++ {
+		addr, err := w.AddressAt(start + i)
+		if err != nil {
+			return false, err
+		}
+
+		used, err := isUsed(addr)
+		if err != nil {
+			return false, err
+		}
+		if used {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
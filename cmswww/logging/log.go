@@ -0,0 +1,148 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package logging provides a leveled, per-subsystem logging backend shared
+// by politeiad and cmswww. Log files are rotated once they grow past a
+// size threshold, with old segments gzipped in place.
+package logging
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/decred/slog"
+	"github.com/jrick/logrotate/rotator"
+)
+
+// subsystemTags are the short tags recognized in a DebugLevel string, e.g.
+// "POLI=debug,CMSW=info", naming the politeiad and cmswww subsystems.
+var subsystemTags = []string{"POLI", "CMSW"}
+
+// Backend rotates a single log file and fans out leveled, tagged loggers
+// for each subsystem that writes to it.
+type Backend struct {
+	rotator *rotator.Rotator
+	backend *slog.Backend
+	verbose bool
+
+	mu      sync.Mutex
+	loggers map[string]slog.Logger
+}
+
+// New opens (creating if necessary) logFile for append and returns a
+// Backend that rotates it once it exceeds 10 MB, gzipping the rolled-off
+// segment. debugLevel configures the initial level of every subsystem
+// logger obtained from the Backend; see SetLevels for its syntax. When
+// verbose is true, every log line is also written to stderr.
+func New(logFile string, debugLevel string, verbose bool) (*Backend, error) {
+	r, err := rotator.New(logFile, 10*1024, true, 3)
+	if err != nil {
+		return nil, fmt.Errorf("open log rotator: %v", err)
+	}
+
+	b := &Backend{
+		rotator: r,
+		verbose: verbose,
+		loggers: make(map[string]slog.Logger),
+	}
+	b.backend = slog.NewBackend(b)
+
+	if err := b.SetLevels(debugLevel); err != nil {
+		r.Close()
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Write implements io.Writer, satisfying slog.Backend's output needs. It
+// always rotates to the log file and, when the Backend is verbose, also
+// mirrors the line to stderr.
+func (b *Backend) Write(p []byte) (int, error) {
+	if b.verbose {
+		os.Stderr.Write(p)
+	}
+	return b.rotator.Write(p)
+}
+
+// Logger returns the leveled logger for subsystem, creating it at info
+// level the first time it is requested; only SetLevels changes the level
+// afterwards. Safe for concurrent use.
+func (b *Backend) Logger(subsystem string) slog.Logger {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if l, ok := b.loggers[subsystem]; ok {
+		return l
+	}
+
+	l := b.backend.Logger(subsystem)
+	l.SetLevel(slog.LevelInfo)
+	b.loggers[subsystem] = l
+	return l
+}
+
+// SetLevels configures the level of the known subsystem loggers in
+// subsystemTags (POLI and CMSW), creating them if needed. debugLevel is
+// either a single level name applied to every known subsystem ("info") or
+// a comma-separated list of subsystem=level pairs ("POLI=debug,CMSW=info");
+// subsystems not mentioned in the list keep their current level. Loggers
+// for any other subsystem are not changed, and a pair naming one is an
+// error.
+func (b *Backend) SetLevels(debugLevel string) error {
+	if debugLevel == "" {
+		return nil
+	}
+
+	// Single global level for every known subsystem.
+	if !strings.Contains(debugLevel, "=") {
+		level, ok := slog.LevelFromString(debugLevel)
+		if !ok {
+			return fmt.Errorf("invalid debug level %q", debugLevel)
+		}
+		for _, tag := range subsystemTags {
+			b.Logger(tag).SetLevel(level)
+		}
+		return nil
+	}
+
+	// Per-subsystem levels: "POLI=debug,CMSW=info".
+	for _, pair := range strings.Split(debugLevel, ",") {
+		fields := strings.Split(pair, "=")
+		if len(fields) != 2 {
+			return fmt.Errorf("invalid debug level pair %q", pair)
+		}
+
+		tag, levelStr := fields[0], fields[1]
+		if !isSupportedSubsystem(tag) {
+			return fmt.Errorf("unknown logging subsystem %q", tag)
+		}
+
+		level, ok := slog.LevelFromString(levelStr)
+		if !ok {
+			return fmt.Errorf("invalid debug level %q for subsystem %q",
+				levelStr, tag)
+		}
+
+		b.Logger(tag).SetLevel(level)
+	}
+
+	return nil
+}
+
+// Close flushes and closes the underlying log file.
+func (b *Backend) Close() {
+	b.rotator.Close()
+}
+
+func isSupportedSubsystem(tag string) bool {
+	for _, s := range subsystemTags {
+		if s == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package totp
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// backupCodeCount is how many one-time backup codes are generated per user.
+const backupCodeCount = 10
+
+// backupCodeBytes is the amount of entropy, in bytes, behind each backup
+// code before it is formatted for display.
+const backupCodeBytes = 5
+
+// GenerateBackupCodes returns backupCodeCount freshly generated one-time
+// codes along with their bcrypt hashes. The plaintext codes must be shown
+// to the user exactly once; only the hashes should be persisted.
+func GenerateBackupCodes() (codes []string, hashes [][]byte, err error) {
+	codes = make([]string, backupCodeCount)
+	hashes = make([][]byte, backupCodeCount)
+
+	for i := range codes {
+		buf := make([]byte, backupCodeBytes)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, fmt.Errorf("read random backup code: %v", err)
+		}
+		code := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf)
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, nil, fmt.Errorf("hash backup code: %v", err)
+		}
+
+		codes[i] = code
+		hashes[i] = hash
+	}
+
+	return codes, hashes, nil
+}
+
+// MatchBackupCode returns the index of the hash in hashes that code
+// matches, or -1 if none match. Callers must remove the matched hash from
+// the stored set so each backup code can only be used once.
+func MatchBackupCode(hashes [][]byte, code string) int {
+	for i, hash := range hashes {
+		if bcrypt.CompareHashAndPassword(hash, []byte(code)) == nil {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2017 The Decred developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// Package totp implements RFC 6238 time-based one-time passwords for
+// second-factor login, including otpauth:// provisioning URIs and
+// verification with a small clock-skew window and replay protection.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// secretSize is the number of random bytes used for a generated
+	// TOTP secret, matching the 160-bit key RFC 6238 recommends for
+	// HMAC-SHA1.
+	secretSize = 20
+
+	// period is the time step, in seconds, a code is valid for.
+	period = 30
+
+	// digits is the number of digits in a generated code.
+	digits = 6
+
+	// window is how many periods on either side of "now" are accepted,
+	// to tolerate clock skew between the server and the user's device.
+	window = 1
+)
+
+// ErrInvalidCode indicates that the supplied code does not match any
+// not-yet-used time step within the accepted window. This also covers a
+// replayed code: the time step it was valid for is skipped once
+// lastUsedCounter reaches it.
+var ErrInvalidCode = errors.New("invalid totp code")
+
+// GenerateSecret returns a new random TOTP secret suitable for passing to
+// ProvisioningURI and Verify.
+func GenerateSecret() ([]byte, error) {
+	secret := make([]byte, secretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("read random secret: %v", err)
+	}
+	return secret, nil
+}
+
+// ProvisioningURI returns an otpauth:// URI encoding secret for account,
+// suitable for rendering as a QR code in an authenticator app. issuer is
+// shown alongside account in the app's list of entries.
+func ProvisioningURI(issuer, account string, secret []byte) string {
+	v := url.Values{}
+	v.Set("secret", base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret))
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", strconv.Itoa(digits))
+	v.Set("period", strconv.Itoa(period))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
+
+// counterAt returns the RFC 6238 time-step counter for t.
+func counterAt(t time.Time) uint64 {
+	return uint64(t.Unix() / period)
+}
+
+// codeAt computes the 6-digit HOTP code (RFC 4226) for secret at the given
+// time-step counter.
+func codeAt(secret []byte, counter uint64) string {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], counter)
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(buf[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	truncated := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	code := truncated % 1_000_000
+	return fmt.Sprintf("%0*d", digits, code)
+}
+
+// Verify checks code against secret, accepting any time step within
+// +/-window of now. lastUsedCounter is the counter value most recently
+// accepted for this secret (0 if TOTP has never been used); Verify rejects
+// any counter at or before it to prevent replay of an already-used code.
+// On success it returns the counter that matched, which the caller must
+// persist as the new lastUsedCounter.
+func Verify(secret []byte, code string, lastUsedCounter uint64, now time.Time) (uint64, error) {
+	current := counterAt(now)
+
+	for offset := -window; offset <= window; offset++ {
+		counter := uint64(int64(current) + int64(offset))
+		if counter <= lastUsedCounter {
+			continue
+		}
+
+		if hmac.Equal([]byte(codeAt(secret, counter)), []byte(code)) {
+			return counter, nil
+		}
+	}
+
+	return 0, ErrInvalidCode
+}